@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/NurlanTanatar/localdns/dnsmsg"
+)
+
+const (
+	// optCodeECS is the EDNS0 Client Subnet option code (RFC 7871).
+	optCodeECS = 8
+
+	defaultUDPPayloadSize = 1232
+)
+
+// ClientSubnet is a parsed EDNS0 Client Subnet (ECS) option (RFC 7871).
+type ClientSubnet struct {
+	Family       uint16
+	SourcePrefix uint8
+	ScopePrefix  uint8
+	Address      net.IP
+}
+
+// EDNSInfo holds everything we care about from a request's OPT
+// pseudo-record.
+type EDNSInfo struct {
+	UDPSize      uint16
+	ExtRcode     uint8
+	Version      uint8
+	DNSSECOK     bool
+	ClientSubnet *ClientSubnet
+}
+
+// parseEDNS scans additionals for an OPT RR (TYPE=41). It returns nil, nil
+// if none is present. A malformed ECS sub-option only drops the
+// ClientSubnet field; it never invalidates the rest of the OPT record, so
+// callers still get the negotiated UDP size even when ECS is garbled.
+func parseEDNS(additionals []dnsmsg.ResourceRecord) (*EDNSInfo, error) {
+	for _, rr := range additionals {
+		if rr.Type != dnsmsg.TypeOPT || rr.Name != "" {
+			continue
+		}
+
+		info := &EDNSInfo{
+			UDPSize:  rr.Class,
+			ExtRcode: uint8(rr.TTL >> 24),
+			Version:  uint8(rr.TTL >> 16),
+			DNSSECOK: rr.TTL&0x00008000 != 0,
+		}
+		if cs, err := parseECSOption(rr.RData); err == nil {
+			info.ClientSubnet = cs
+		}
+		return info, nil
+	}
+	return nil, nil
+}
+
+// parseECSOption walks an OPT RR's RDATA looking for an EDNS0 Client Subnet
+// option (OPTION-CODE 8).
+func parseECSOption(rdata []byte) (*ClientSubnet, error) {
+	pos := 0
+	for pos+4 <= len(rdata) {
+		code := binary.BigEndian.Uint16(rdata[pos : pos+2])
+		length := binary.BigEndian.Uint16(rdata[pos+2 : pos+4])
+		pos += 4
+		if pos+int(length) > len(rdata) {
+			return nil, errors.New("EDNS option runs past end of OPT RDATA")
+		}
+		optData := rdata[pos : pos+int(length)]
+		pos += int(length)
+
+		if code != optCodeECS {
+			continue
+		}
+		if len(optData) < 4 {
+			return nil, errors.New("ECS option too short")
+		}
+		family := binary.BigEndian.Uint16(optData[0:2])
+		srcPrefix := optData[2]
+		scopePrefix := optData[3]
+		addrBytes := optData[4:]
+
+		ip := make(net.IP, 4)
+		if family == 2 {
+			ip = make(net.IP, 16)
+		}
+		copy(ip, addrBytes)
+
+		return &ClientSubnet{
+			Family:       family,
+			SourcePrefix: srcPrefix,
+			ScopePrefix:  scopePrefix,
+			Address:      ip,
+		}, nil
+	}
+	return nil, nil
+}
+
+// buildOPTResourceRecord encodes our own OPT pseudo-record: root name,
+// TYPE=41, CLASS=our advertised UDP payload size, and an empty or
+// ECS-echoing RDATA.
+func buildOPTResourceRecord(udpSize uint16, scopePrefix uint8, ecs *ClientSubnet) dnsmsg.ResourceRecord {
+	var rdata []byte
+	if ecs != nil {
+		rdata = ecsOptionBytes(ecs, scopePrefix)
+	}
+	return dnsmsg.ResourceRecord{
+		Type:  dnsmsg.TypeOPT,
+		Class: udpSize,
+		TTL:   0, // extended-rcode=0, version=0, DO=0
+		RData: rdata,
+	}
+}
+
+// ecsOptionBytes encodes an EDNS0 Client Subnet option, truncating the
+// address to SourcePrefix bits as RFC 7871 requires.
+func ecsOptionBytes(ecs *ClientSubnet, scopePrefix uint8) []byte {
+	addrLen := (int(ecs.SourcePrefix) + 7) / 8
+	if addrLen > len(ecs.Address) {
+		addrLen = len(ecs.Address)
+	}
+	addr := ecs.Address[:addrLen]
+
+	data := make([]byte, 4+len(addr))
+	binary.BigEndian.PutUint16(data[0:2], ecs.Family)
+	data[2] = ecs.SourcePrefix
+	data[3] = scopePrefix
+	copy(data[4:], addr)
+	if rem := ecs.SourcePrefix % 8; rem != 0 && addrLen == (int(ecs.SourcePrefix)+7)/8 {
+		data[4+addrLen-1] &= 0xFF << (8 - rem)
+	}
+
+	option := make([]byte, 4)
+	binary.BigEndian.PutUint16(option[0:2], optCodeECS)
+	binary.BigEndian.PutUint16(option[2:4], uint16(len(data)))
+	return append(option, data...)
+}
+
+// ecsFromIP synthesizes a ClientSubnet option from a client's source
+// address, for forwarding upstream when the client didn't send one itself.
+func ecsFromIP(ip net.IP) *ClientSubnet {
+	if ip == nil {
+		return nil
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &ClientSubnet{Family: 1, SourcePrefix: 24, Address: v4}
+	}
+	return &ClientSubnet{Family: 2, SourcePrefix: 56, Address: ip.To16()}
+}
+
+// addECSIfMissing appends an OPT RR carrying a synthesized Client Subnet
+// option to query when the client didn't attach an OPT record at all, so
+// upstream resolvers still get geo hints when forwarding on the client's
+// behalf. A query that already has an OPT record is returned unchanged.
+func addECSIfMissing(query []byte, clientIP net.IP) []byte {
+	var msg dnsmsg.Message
+	if err := msg.Unpack(query); err != nil {
+		return query
+	}
+
+	edns, err := parseEDNS(msg.Additionals)
+	if err != nil || edns != nil {
+		// Either malformed, or the client already negotiated EDNS0 (with or
+		// without its own ECS option) — leave the additional section alone.
+		return query
+	}
+
+	ecs := ecsFromIP(clientIP)
+	if ecs == nil {
+		return query
+	}
+
+	msg.Additionals = append(msg.Additionals, buildOPTResourceRecord(defaultUDPPayloadSize, 0, ecs))
+	packed, err := msg.Pack()
+	if err != nil {
+		return query
+	}
+	return packed
+}
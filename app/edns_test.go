@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/NurlanTanatar/localdns/dnsmsg"
+)
+
+func ecsOptionRDATA(family uint16, srcPrefix, scopePrefix uint8, addr []byte) []byte {
+	data := make([]byte, 4+len(addr))
+	binary.BigEndian.PutUint16(data[0:2], family)
+	data[2] = srcPrefix
+	data[3] = scopePrefix
+	copy(data[4:], addr)
+
+	option := make([]byte, 4)
+	binary.BigEndian.PutUint16(option[0:2], optCodeECS)
+	binary.BigEndian.PutUint16(option[2:4], uint16(len(data)))
+	return append(option, data...)
+}
+
+func TestParseECSOptionValid(t *testing.T) {
+	rdata := ecsOptionRDATA(1, 24, 0, []byte{192, 0, 2, 0})
+
+	cs, err := parseECSOption(rdata)
+	if err != nil {
+		t.Fatalf("parseECSOption: %v", err)
+	}
+	if cs == nil {
+		t.Fatal("expected a ClientSubnet, got nil")
+	}
+	if cs.Family != 1 || cs.SourcePrefix != 24 {
+		t.Errorf("unexpected ClientSubnet: %+v", cs)
+	}
+	if !cs.Address.Equal(net.IPv4(192, 0, 2, 0)) {
+		t.Errorf("unexpected address: %v", cs.Address)
+	}
+}
+
+func TestParseECSOptionAbsent(t *testing.T) {
+	cs, err := parseECSOption(nil)
+	if err != nil {
+		t.Fatalf("parseECSOption: %v", err)
+	}
+	if cs != nil {
+		t.Errorf("expected no ClientSubnet, got %+v", cs)
+	}
+}
+
+func TestParseECSOptionTruncated(t *testing.T) {
+	// OPTION-LENGTH claims 4 bytes of option data but only 2 follow.
+	rdata := []byte{0x00, 0x08, 0x00, 0x04, 0x00, 0x01}
+
+	if _, err := parseECSOption(rdata); err == nil {
+		t.Fatal("expected an error for a truncated ECS option")
+	}
+}
+
+func TestParseECSOptionTooShort(t *testing.T) {
+	// Well-framed (OPTION-LENGTH matches what follows) but too small to hold
+	// a family + prefixes.
+	rdata := []byte{0x00, 0x08, 0x00, 0x02, 0x00, 0x01}
+
+	if _, err := parseECSOption(rdata); err == nil {
+		t.Fatal("expected an error for an undersized ECS option")
+	}
+}
+
+func TestParseECSOptionAmongOthers(t *testing.T) {
+	// An unrelated option (code 99) followed by a real ECS option; the scan
+	// has to skip over the first to find the second.
+	other := []byte{0x00, 0x63, 0x00, 0x02, 0xAA, 0xBB}
+	ecs := ecsOptionRDATA(1, 32, 0, []byte{10, 0, 0, 1})
+	rdata := append(other, ecs...)
+
+	cs, err := parseECSOption(rdata)
+	if err != nil {
+		t.Fatalf("parseECSOption: %v", err)
+	}
+	if cs == nil || cs.SourcePrefix != 32 {
+		t.Errorf("expected to find the ECS option after the unrelated one, got %+v", cs)
+	}
+}
+
+func TestParseEDNSNoOPT(t *testing.T) {
+	info, err := parseEDNS(nil)
+	if err != nil {
+		t.Fatalf("parseEDNS: %v", err)
+	}
+	if info != nil {
+		t.Errorf("expected nil EDNSInfo, got %+v", info)
+	}
+}
+
+func TestParseEDNSValidECS(t *testing.T) {
+	opt := buildOPTResourceRecord(4096, 0, &ClientSubnet{Family: 1, SourcePrefix: 24, Address: net.IPv4(192, 0, 2, 0).To4()})
+
+	info, err := parseEDNS([]dnsmsg.ResourceRecord{opt})
+	if err != nil {
+		t.Fatalf("parseEDNS: %v", err)
+	}
+	if info == nil {
+		t.Fatal("expected EDNSInfo, got nil")
+	}
+	if info.UDPSize != 4096 {
+		t.Errorf("expected UDPSize 4096, got %d", info.UDPSize)
+	}
+	if info.ClientSubnet == nil || info.ClientSubnet.SourcePrefix != 24 {
+		t.Errorf("expected ClientSubnet to round-trip, got %+v", info.ClientSubnet)
+	}
+}
+
+// TestParseEDNSMalformedECSKeepsUDPSize is the regression test for the bug
+// fixed in 2e55b31: a malformed ECS sub-option must only drop the
+// ClientSubnet, not the whole OPT record's negotiated UDP size.
+func TestParseEDNSMalformedECSKeepsUDPSize(t *testing.T) {
+	opt := dnsmsg.ResourceRecord{
+		Type:  dnsmsg.TypeOPT,
+		Class: 4096,
+		RData: []byte{0x00, 0x08, 0x00, 0x04, 0x00, 0x01}, // OPTION-LENGTH runs past the data
+	}
+
+	info, err := parseEDNS([]dnsmsg.ResourceRecord{opt})
+	if err != nil {
+		t.Fatalf("parseEDNS returned an error for a malformed ECS option: %v", err)
+	}
+	if info == nil {
+		t.Fatal("expected EDNSInfo to survive a malformed ECS option")
+	}
+	if info.UDPSize != 4096 {
+		t.Errorf("expected UDPSize to still be negotiated, got %d", info.UDPSize)
+	}
+	if info.ClientSubnet != nil {
+		t.Errorf("expected ClientSubnet to be dropped, got %+v", info.ClientSubnet)
+	}
+}
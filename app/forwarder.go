@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/NurlanTanatar/localdns/dnsmsg"
+)
+
+const (
+	forwardTimeout  = 5 * time.Second
+	upstreamStagger = 50 * time.Millisecond
+)
+
+// Forwarder proxies queries that aren't answered by the local zone to a
+// list of upstream resolvers, coalescing duplicate concurrent queries so
+// that a burst of identical retransmits only generates one upstream
+// round-trip.
+type Forwarder struct {
+	upstreams []string
+	conn      *net.UDPConn
+
+	mu       sync.Mutex
+	inFlight map[uint64][]chan []byte
+}
+
+// NewForwarder opens a single outbound UDP socket shared by every query and
+// starts its response-reading loop.
+func NewForwarder(upstreams []string) (*Forwarder, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.New("no upstream resolvers configured")
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("opening forwarder socket: %w", err)
+	}
+
+	f := &Forwarder{
+		upstreams: upstreams,
+		conn:      conn,
+		inFlight:  make(map[uint64][]chan []byte),
+	}
+	go f.readLoop()
+	return f, nil
+}
+
+// txID identifies an in-flight query by the zero-extended 16-bit DNS ID in
+// the low 32 bits and the CRC32 (IEEE) checksum of the first question
+// section (QNAME+QTYPE+QCLASS, re-encoded without compression so it's
+// canonical regardless of how the client wrote it) in the high 32 bits,
+// making accidental collisions between unrelated queries sharing the one
+// socket negligible.
+func txID(packet []byte) (uint64, error) {
+	var msg dnsmsg.Message
+	if err := msg.Unpack(packet); err != nil {
+		return 0, fmt.Errorf("parsing packet for txid: %w", err)
+	}
+	if len(msg.Questions) == 0 {
+		return 0, errors.New("txid: packet has no question")
+	}
+
+	question := dnsmsg.Message{Questions: msg.Questions[:1]}
+	packed, err := question.Pack()
+	if err != nil {
+		return 0, fmt.Errorf("re-encoding question for txid: %w", err)
+	}
+	checksum := crc32.ChecksumIEEE(packed[12:]) // skip the zeroed header Pack() always prepends
+
+	return uint64(checksum)<<32 | uint64(msg.Header.ID), nil
+}
+
+// Forward sends query to the upstream resolvers, deduplicating against any
+// identical query already in flight, and returns the first valid reply.
+// clientIP, if set, is used to synthesize an EDNS0 Client Subnet option when
+// the client's query carries no OPT record at all; a query that already has
+// one is forwarded as is, whether or not it includes ECS.
+func (f *Forwarder) Forward(query []byte, clientIP net.IP) ([]byte, error) {
+	query = addECSIfMissing(query, clientIP)
+
+	txid, err := txID(query)
+	if err != nil {
+		return nil, err
+	}
+
+	respCh := make(chan []byte, 1)
+
+	f.mu.Lock()
+	waiters, alreadyInFlight := f.inFlight[txid]
+	f.inFlight[txid] = append(waiters, respCh)
+	f.mu.Unlock()
+
+	if !alreadyInFlight {
+		go f.send(query)
+	}
+
+	select {
+	case resp := <-respCh:
+		return f.maybeRetryTCP(query, resp)
+	case <-time.After(forwardTimeout):
+		f.removeWaiter(txid, respCh)
+		return nil, errors.New("forwarder: timed out waiting for upstream reply")
+	}
+}
+
+// send races the configured upstreams with a small staggered start delay
+// between each, so a fast resolver wins without every query fanning out to
+// every upstream simultaneously.
+func (f *Forwarder) send(query []byte) {
+	for i, upstream := range f.upstreams {
+		delay := time.Duration(i) * upstreamStagger
+		go func(upstream string, delay time.Duration) {
+			time.Sleep(delay)
+			addr, err := net.ResolveUDPAddr("udp", upstream)
+			if err != nil {
+				fmt.Println("forwarder: bad upstream address:", err)
+				return
+			}
+			if _, err := f.conn.WriteToUDP(query, addr); err != nil {
+				fmt.Println("forwarder: write to upstream failed:", err)
+			}
+		}(upstream, delay)
+	}
+}
+
+// readLoop delivers every reply read from the shared socket to whichever
+// queries are waiting on its recomputed txid.
+func (f *Forwarder) readLoop() {
+	buf := make([]byte, 65535)
+	for {
+		size, _, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		resp := append([]byte{}, buf[:size]...)
+		txid, err := txID(resp)
+		if err != nil {
+			continue
+		}
+
+		f.mu.Lock()
+		waiters := f.inFlight[txid]
+		delete(f.inFlight, txid)
+		f.mu.Unlock()
+
+		for _, ch := range waiters {
+			ch <- resp
+		}
+	}
+}
+
+func (f *Forwarder) removeWaiter(txid uint64, respCh chan []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	waiters := f.inFlight[txid]
+	for i, ch := range waiters {
+		if ch == respCh {
+			f.inFlight[txid] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(f.inFlight[txid]) == 0 {
+		delete(f.inFlight, txid)
+	}
+}
+
+// maybeRetryTCP re-issues the query over TCP whenever the UDP reply came
+// back truncated (TC=1), trying each configured upstream in order until one
+// answers; if none does, the truncated UDP reply is returned.
+func (f *Forwarder) maybeRetryTCP(query, udpResp []byte) ([]byte, error) {
+	if len(udpResp) < 3 || udpResp[2]&0b00000010 == 0 {
+		return udpResp, nil
+	}
+
+	var lastErr error
+	for _, upstream := range f.upstreams {
+		resp, err := queryTCP(upstream, query)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	fmt.Println("forwarder: TCP retry after TC=1 failed, returning truncated UDP reply:", lastErr)
+	return udpResp, nil
+}
+
+// queryTCP performs a single length-prefixed DNS query over TCP, per RFC
+// 1035 §4.2.2.
+func queryTCP(upstream string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", upstream, forwardTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(forwardTimeout))
+
+	lenPrefix := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenPrefix, uint16(len(query)))
+	if _, err := conn.Write(append(lenPrefix, query...)); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	var respLen uint16
+	if err := binary.Read(reader, binary.BigEndian, &respLen); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, respLen)
+	if _, err := readFull(reader, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func readFull(reader *bufio.Reader, buf []byte) (int, error) {
+	total := 0
+	for total < len(buf) {
+		n, err := reader.Read(buf[total:])
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
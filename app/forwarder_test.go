@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NurlanTanatar/localdns/dnsmsg"
+)
+
+func packTestQuery(id uint16, name string, qtype uint16) []byte {
+	msg := dnsmsg.Message{
+		Header:    dnsmsg.Header{ID: id, RD: true},
+		Questions: []dnsmsg.Question{{Name: name, Type: qtype, Class: dnsmsg.ClassIN}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		panic(err)
+	}
+	return packed
+}
+
+func TestTxIDSameQuestionDifferentID(t *testing.T) {
+	a := packTestQuery(1, "example.com", dnsmsg.TypeA)
+	b := packTestQuery(2, "example.com", dnsmsg.TypeA)
+
+	idA, err := txID(a)
+	if err != nil {
+		t.Fatalf("txID: %v", err)
+	}
+	idB, err := txID(b)
+	if err != nil {
+		t.Fatalf("txID: %v", err)
+	}
+	if idA == idB {
+		t.Fatal("expected different txids for different DNS IDs")
+	}
+	if idA>>32 != idB>>32 {
+		t.Error("expected the same question checksum (high 32 bits) for the same question")
+	}
+}
+
+func TestTxIDSameIDDifferentQuestion(t *testing.T) {
+	a := packTestQuery(1, "example.com", dnsmsg.TypeA)
+	b := packTestQuery(1, "example.org", dnsmsg.TypeA)
+
+	idA, err := txID(a)
+	if err != nil {
+		t.Fatalf("txID: %v", err)
+	}
+	idB, err := txID(b)
+	if err != nil {
+		t.Fatalf("txID: %v", err)
+	}
+	if idA == idB {
+		t.Fatal("expected different txids for different questions")
+	}
+	if idA>>32 == idB>>32 {
+		t.Error("expected different question checksums for different questions")
+	}
+}
+
+// TestTxIDIgnoresSurroundingSections confirms txID's checksum is derived
+// solely from the re-encoded first question, not from whatever else the
+// packet carries: the same question with the same ID must hash identically
+// whether or not the packet also carries an EDNS OPT record (the case
+// Forward actually hits, since addECSIfMissing may add one before txID
+// dedupes the query).
+func TestTxIDIgnoresSurroundingSections(t *testing.T) {
+	plain := packTestQuery(42, "example.com", dnsmsg.TypeA)
+
+	withOPT := dnsmsg.Message{
+		Header:      dnsmsg.Header{ID: 42, RD: true},
+		Questions:   []dnsmsg.Question{{Name: "example.com", Type: dnsmsg.TypeA, Class: dnsmsg.ClassIN}},
+		Additionals: []dnsmsg.ResourceRecord{buildOPTResourceRecord(4096, 0, nil)},
+	}
+	withOPTBytes, err := withOPT.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+
+	idPlain, err := txID(plain)
+	if err != nil {
+		t.Fatalf("txID plain: %v", err)
+	}
+	idOPT, err := txID(withOPTBytes)
+	if err != nil {
+		t.Fatalf("txID with OPT: %v", err)
+	}
+	if idPlain != idOPT {
+		t.Errorf("expected matching txids, got %x and %x", idPlain, idOPT)
+	}
+}
+
+// TestForwardCoalescesDuplicateQueries drives Forward with two concurrent,
+// identical queries against a fake upstream and checks that only one of
+// them actually reaches the network — the second should be coalesced onto
+// the first's in-flight wait — and that both callers still get a reply.
+func TestForwardCoalescesDuplicateQueries(t *testing.T) {
+	upstreamConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer upstreamConn.Close()
+
+	var received int32
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstreamConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&received, 1)
+			resp := append([]byte{}, buf[:n]...)
+			time.Sleep(20 * time.Millisecond)
+			upstreamConn.WriteToUDP(resp, addr)
+		}
+	}()
+
+	f, err := NewForwarder([]string{upstreamConn.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("NewForwarder: %v", err)
+	}
+
+	query := packTestQuery(7, "example.com", dnsmsg.TypeA)
+
+	var wg sync.WaitGroup
+	results := make([][]byte, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := f.Forward(query, nil)
+			if err != nil {
+				t.Errorf("Forward: %v", err)
+				return
+			}
+			results[i] = resp
+		}(i)
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&received); got != 1 {
+		t.Errorf("expected exactly 1 upstream send for duplicate concurrent queries, got %d", got)
+	}
+	if len(results[0]) == 0 || len(results[1]) == 0 {
+		t.Fatal("expected both callers to receive a response")
+	}
+}
@@ -2,13 +2,42 @@ package main
 
 import (
 	"encoding/hex"
-	"errors"
+	"flag"
 	"fmt"
 	"net"
+	"strings"
+
+	"github.com/NurlanTanatar/localdns/dnsmsg"
 )
 
 func main() {
-	udpAddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:2053")
+	zoneFile := flag.String("zone", "zones.json", "path to the zone config file")
+	listenAddr := flag.String("addr", "127.0.0.1:2053", "UDP and TCP address to listen on")
+	upstreams := flag.String("upstream", "", "comma-separated upstream resolvers to forward non-local queries to, e.g. 1.1.1.1:53,8.8.8.8:53")
+	mdns := flag.Bool("mdns", false, "also answer mDNS queries for .local names in the zone on 224.0.0.251:5353 / [ff02::fb]:5353")
+	flag.Parse()
+
+	zones, err := LoadZoneFile(*zoneFile)
+	if err != nil {
+		fmt.Println("Failed to load zone file, serving empty zone:", err)
+		zones = &ZoneStore{}
+	}
+
+	var forwarder *Forwarder
+	if *upstreams != "" {
+		forwarder, err = NewForwarder(strings.Split(*upstreams, ","))
+		if err != nil {
+			fmt.Println("Failed to start forwarder, non-local queries will NXDOMAIN:", err)
+		}
+	}
+
+	if *mdns {
+		RunMDNS(zones)
+	}
+
+	go RunTCPServer(*listenAddr, zones, forwarder)
+
+	udpAddr, err := net.ResolveUDPAddr("udp", *listenAddr)
 	if err != nil {
 		fmt.Println("Failed to resolve UDP address:", err)
 		return
@@ -21,7 +50,7 @@ func main() {
 	}
 	defer udpConn.Close()
 
-	buf := make([]byte, 512)
+	buf := make([]byte, 4096)
 
 	for {
 		size, source, err := udpConn.ReadFromUDP(buf)
@@ -35,7 +64,7 @@ func main() {
 		fmt.Printf("Hex dump of received data:\n%s\n", hex.Dump(buf[:size]))
 
 		// Parse the query and create response
-		response := createResponse(buf[:size])
+		response := createResponse(buf[:size], zones, forwarder, source.IP, false)
 
 		// Print response we're sending back
 		fmt.Printf("\nSending response (%d bytes):\n", len(response))
@@ -48,163 +77,181 @@ func main() {
 	}
 }
 
-func createResponse(query []byte) []byte {
-	// Calculate total response size: 8 (header) + domain name size + 4 (QTYPE + QCLASS)
-	// For localdns.io: 8 (header) + 15 (domain name with length bytes) + 4 = 27 bytes
-	response := make([]byte, len(query))
-
-	// 1. Copy first 8 bytes (header) from query
-	copy(response, query)
-
-	// // 2. Set the ID to 1234 (0x04D2 in hex)
-	// response[0] = 0x04
-	// response[1] = 0xD2
-
-	// 3. Set QR bit to 1 (response)
-	response[2] = response[2] | 0b10000000
+// maxTCPResponseSize is the practical ceiling for a TCP response: the
+// 2-byte length prefix can address up to 65535 bytes.
+const maxTCPResponseSize = 65535
+
+// createResponse parses the first question in raw against zones and
+// synthesizes a full reply: the echoed question section followed by an
+// answer RR per matching record, with RCODE set to NXDOMAIN when the name
+// isn't defined at all and NOERROR with an empty answer section when the
+// name exists but has no records of the requested type. Names not in the
+// zone are handed off to forwarder, when configured. overTCP lifts the
+// response size limit to maxTCPResponseSize instead of the UDP/EDNS one.
+func createResponse(raw []byte, zones *ZoneStore, forwarder *Forwarder, clientIP net.IP, overTCP bool) []byte {
+	var query dnsmsg.Message
+	if err := query.Unpack(raw); err != nil {
+		fmt.Println("Failed to parse query:", err)
+		return nxdomainResponse(raw)
+	}
+	if len(query.Questions) == 0 {
+		return nxdomainResponse(raw)
+	}
+	q := query.Questions[0]
 
-	// 4. Set QDCOUNT to 1 (we're including one question)
-	response[4] = 0x00
-	response[5] = 0x01
+	edns, err := parseEDNS(query.Additionals)
+	if err != nil {
+		fmt.Println("Failed to parse EDNS, ignoring OPT record:", err)
+		edns = nil
+	}
 
-	// 5. Add the question section starting at byte 12
-	offset := 12
+	maxSize := 512
+	if edns != nil && int(edns.UDPSize) > maxSize {
+		maxSize = int(edns.UDPSize)
+	}
+	if overTCP {
+		maxSize = maxTCPResponseSize
+	}
 
-	// Add "localdns" label
-	response[offset] = 0x0c
-	copy(response[offset+1:offset+13], []byte("localdns"))
-	offset += 13
+	recs, nameExists := zones.Lookup(q.Name, q.Type)
+	if !nameExists && forwarder != nil {
+		if resp, err := forwarder.Forward(raw, clientIP); err == nil {
+			// The upstream query carries a size hint of its own (set by
+			// addECSIfMissing, or the client's if it sent EDNS), but that's
+			// independent of maxSize here, which is what the original client
+			// actually negotiated with us — so the reply still needs the same
+			// truncation the local-answer path below applies.
+			return truncateForwardedResponse(resp, maxSize)
+		} else {
+			fmt.Println("Forwarding failed, answering NXDOMAIN:", err)
+		}
+	}
 
-	// Add "io" label
-	response[offset] = 0x02                         // length of "io"
-	copy(response[offset+1:offset+3], []byte("io")) // the string "io"
-	offset += 3
+	reply := dnsmsg.Message{}
+	reply.SetReply(&query)
+	if !nameExists {
+		dnsmsg.SetRcode(&reply, dnsmsg.RcodeNXDomain)
+	}
 
-	// Add null byte to terminate domain name
-	response[offset] = 0x00
-	offset++
+	if edns != nil {
+		reply.Additionals = []dnsmsg.ResourceRecord{buildOPTResourceRecord(defaultUDPPayloadSize, 0, edns.ClientSubnet)}
+	}
 
-	// Add QTYPE (1 for A record)
-	response[offset] = 0x00
-	response[offset+1] = 0x01
-	offset += 2
+	// Add answers one at a time, re-packing after each to check the
+	// negotiated size limit; this accounts for compression savings as names
+	// repeat, rather than estimating sizes ahead of time.
+	for _, rec := range recs {
+		rdata, err := buildRDATA(rec)
+		if err != nil {
+			fmt.Println("Skipping record:", err)
+			continue
+		}
+		rr := dnsmsg.ResourceRecord{Name: q.Name, Type: recordTypeValue(rec.Type), Class: q.Class, TTL: rec.TTL, RData: rdata}
 
-	// Add QCLASS (1 for IN)
-	response[offset] = 0x00
-	response[offset+1] = 0x01
+		trial := reply
+		trial.Answers = append(append([]dnsmsg.ResourceRecord{}, reply.Answers...), rr)
+		packed, err := trial.Pack()
+		if err != nil {
+			fmt.Println("Failed to pack candidate answer:", err)
+			continue
+		}
+		if len(packed) > maxSize {
+			reply.Header.TC = true
+			break
+		}
+		reply.Answers = trial.Answers
+	}
 
+	response, err := reply.Pack()
+	if err != nil {
+		fmt.Println("Failed to pack response:", err)
+		return nxdomainResponse(raw)
+	}
 	return response
 }
 
-// ParseDomainName reads a domain name from a DNS packet
-// The offset is where to start reading in the packet
-// Returns the domain name and the number of bytes read
-func parseDomainName(packet []byte, offset int) (string, int, error) {
-	if offset >= len(packet) {
-		return "", 0, errors.New("offset beyond packet length")
+// truncateForwardedResponse enforces maxSize on a reply relayed from an
+// upstream resolver. A forwarded reply is never checked against the
+// original client's negotiated size by anything upstream of us, so it can
+// come back larger than a non-EDNS client can handle even with TC=0; this
+// re-applies the same incremental-answer/TC=1 truncation the local-answer
+// path in createResponse uses, dropping authority/additional records first.
+func truncateForwardedResponse(resp []byte, maxSize int) []byte {
+	if len(resp) <= maxSize {
+		return resp
 	}
 
-	// Position in the current packet
-	pos := offset
-	// Final domain name
-	var domain string
-	// Keep track of bytes read
-	bytesRead := 0
-
-	// Read until we hit a 0 length label or end of packet
-	for {
-		// Get the length of the next label
-		if pos >= len(packet) {
-			return "", 0, errors.New("incomplete domain name")
-		}
-		length := int(packet[pos])
-
-		// If length is 0, we're done
-		if length == 0 {
-			bytesRead++
-			break
-		}
+	var msg dnsmsg.Message
+	if err := msg.Unpack(resp); err != nil {
+		fmt.Println("Failed to parse oversized forwarded response, dropping it:", err)
+		return nxdomainResponse(resp)
+	}
 
-		// Move past length byte
-		pos++
-		bytesRead++
+	answers := msg.Answers
+	msg.Answers = nil
+	msg.Authorities = nil
+	msg.Additionals = nil
+	msg.Header.TC = true
 
-		// Check if we have enough bytes for this label
-		if pos+length > len(packet) {
-			return "", 0, errors.New("incomplete label")
+	for _, rr := range answers {
+		trial := msg
+		trial.Answers = append(append([]dnsmsg.ResourceRecord{}, msg.Answers...), rr)
+		packed, err := trial.Pack()
+		if err != nil {
+			continue
 		}
-
-		// Add a dot if this isn't the first label
-		if domain != "" {
-			domain += "."
+		if len(packed) > maxSize {
+			break
 		}
-
-		// Add the label to our domain
-		domain += string(packet[pos : pos+length])
-
-		// Move position to after this label
-		pos += length
-		bytesRead += length
+		msg.Answers = trial.Answers
 	}
 
-	return domain, bytesRead, nil
-}
-
-// parseQuestion parses a single question from a DNS packet
-func parseQuestion(packet []byte, offset int) (domain string, qtype uint16, qclass uint16, bytesRead int, err error) {
-	// Parse domain name
-	domain, nameBytes, err := parseDomainName(packet, offset)
+	packed, err := msg.Pack()
 	if err != nil {
-		return "", 0, 0, 0, err
+		fmt.Println("Failed to pack truncated forwarded response:", err)
+		return nxdomainResponse(resp)
 	}
-	bytesRead = nameBytes
+	return packed
+}
 
-	// Check if we have enough bytes for QTYPE and QCLASS (4 bytes total)
-	if offset+bytesRead+4 > len(packet) {
-		return "", 0, 0, 0, errors.New("packet too short for question section")
+// nxdomainResponse builds a minimal NXDOMAIN reply, used when raw can't
+// even be parsed enough to build a normal response.
+func nxdomainResponse(raw []byte) []byte {
+	reply := dnsmsg.Message{}
+	var query dnsmsg.Message
+	if err := query.Unpack(raw); err == nil {
+		reply.SetReply(&query)
+	} else if len(raw) >= 2 {
+		reply.Header.ID = uint16(raw[0])<<8 | uint16(raw[1])
+		reply.Header.QR = true
 	}
+	dnsmsg.SetRcode(&reply, dnsmsg.RcodeNXDomain)
 
-	// Read QTYPE (2 bytes)
-	qtype = uint16(packet[offset+bytesRead])<<8 | uint16(packet[offset+bytesRead+1])
-	bytesRead += 2
-
-	// Read QCLASS (2 bytes)
-	qclass = uint16(packet[offset+bytesRead])<<8 | uint16(packet[offset+bytesRead+1])
-	bytesRead += 2
-
-	return domain, qtype, qclass, bytesRead, nil
+	response, err := reply.Pack()
+	if err != nil {
+		return nil
+	}
+	return response
 }
 
-// Example usage in your main DNS server:
+// HandleDNSPacket logs the parsed contents of a raw DNS packet; kept around
+// as a quick way to eyeball what a client sent.
 func HandleDNSPacket(packet []byte) {
-	if len(packet) < 12 {
-		fmt.Println("Packet too short")
+	var msg dnsmsg.Message
+	if err := msg.Unpack(packet); err != nil {
+		fmt.Println("Error parsing packet:", err)
 		return
 	}
 
-	// Parse header fields
-	id := uint16(packet[0])<<8 | uint16(packet[1])
-	flags := uint16(packet[2])<<8 | uint16(packet[3])
-	qdcount := uint16(packet[4])<<8 | uint16(packet[5])
-
-	fmt.Printf("DNS Query ID: %d\n", id)
-	fmt.Printf("Flags: %016b\n", flags)
-	fmt.Printf("Questions: %d\n", qdcount)
-
-	// Parse questions
-	offset := 12 // Start after header
-	for i := 0; i < int(qdcount); i++ {
-		domain, qtype, qclass, bytesRead, err := parseQuestion(packet, offset)
-		if err != nil {
-			fmt.Printf("Error parsing question: %v\n", err)
-			return
-		}
+	fmt.Printf("DNS Query ID: %d\n", msg.Header.ID)
+	fmt.Printf("Opcode: %d, AA: %v, TC: %v, RD: %v, RA: %v, RCODE: %d\n",
+		msg.Header.Opcode, msg.Header.AA, msg.Header.TC, msg.Header.RD, msg.Header.RA, msg.Header.RCODE)
+	fmt.Printf("Questions: %d\n", len(msg.Questions))
 
+	for i, q := range msg.Questions {
 		fmt.Printf("Question %d:\n", i+1)
-		fmt.Printf("  Domain: %s\n", domain)
-		fmt.Printf("  Type: %d\n", qtype)
-		fmt.Printf("  Class: %d\n", qclass)
-
-		offset += bytesRead
+		fmt.Printf("  Domain: %s\n", q.Name)
+		fmt.Printf("  Type: %d\n", q.Type)
+		fmt.Printf("  Class: %d\n", q.Class)
 	}
 }
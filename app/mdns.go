@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/NurlanTanatar/localdns/dnsmsg"
+)
+
+const (
+	mdnsV4Addr = "224.0.0.251:5353"
+	mdnsV6Addr = "[ff02::fb]:5353"
+	mdnsTTL    = 120
+
+	mdnsClassUnicastBit = 0x8000
+	classIN             = dnsmsg.ClassIN
+)
+
+// RunMDNS joins the standard mDNS multicast groups (RFC 6762) on both
+// IPv4 and IPv6 and answers A/AAAA queries for any name in zones ending in
+// ".local". Each group is served on its own goroutine, so RunMDNS returns
+// immediately.
+func RunMDNS(zones *ZoneStore) {
+	go serveMDNS("udp4", mdnsV4Addr, zones)
+	go serveMDNS("udp6", mdnsV6Addr, zones)
+}
+
+func serveMDNS(network, groupAddr string, zones *ZoneStore) {
+	addr, err := net.ResolveUDPAddr(network, groupAddr)
+	if err != nil {
+		fmt.Printf("mdns: failed to resolve %s: %v\n", groupAddr, err)
+		return
+	}
+
+	conn, err := net.ListenMulticastUDP(network, nil, addr)
+	if err != nil {
+		fmt.Printf("mdns: failed to join %s: %v\n", groupAddr, err)
+		return
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 4096)
+	for {
+		size, source, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			fmt.Printf("mdns: read error on %s: %v\n", groupAddr, err)
+			return
+		}
+		handleMDNSQuery(conn, addr, source, buf[:size], zones)
+	}
+}
+
+// handleMDNSQuery answers a single mDNS query, replying unicast to source
+// when the top bit of QCLASS (the "unicast response requested" flag) is
+// set, and to the multicast group otherwise.
+func handleMDNSQuery(conn *net.UDPConn, group, source *net.UDPAddr, query []byte, zones *ZoneStore) {
+	var msg dnsmsg.Message
+	if err := msg.Unpack(query); err != nil || len(msg.Questions) == 0 {
+		return
+	}
+	q := msg.Questions[0]
+
+	if !strings.HasSuffix(strings.ToLower(q.Name), ".local") {
+		return
+	}
+	if q.Type != dnsmsg.TypeA && q.Type != dnsmsg.TypeAAAA {
+		return
+	}
+
+	unicastRequested := q.Class&mdnsClassUnicastBit != 0
+	if q.Class&^mdnsClassUnicastBit != classIN {
+		return
+	}
+
+	recs, nameExists := zones.Lookup(q.Name, q.Type)
+	if !nameExists || len(recs) == 0 {
+		return
+	}
+
+	response := buildMDNSResponse(q.Name, classIN, recs)
+
+	dest := group
+	if unicastRequested {
+		dest = source
+	}
+	if _, err := conn.WriteToUDP(response, dest); err != nil {
+		fmt.Println("mdns: failed to send reply:", err)
+	}
+}
+
+// buildMDNSResponse builds an mDNS reply per RFC 6762: ID=0, QR=1, AA=1, and
+// one answer RR per matching record (no question section is required in an
+// mDNS response).
+func buildMDNSResponse(domain string, qclass uint16, recs []RecordConfig) []byte {
+	reply := dnsmsg.Message{}
+	reply.Header.QR = true
+	reply.Header.AA = true
+
+	for _, rec := range recs {
+		rdata, err := buildRDATA(rec)
+		if err != nil {
+			continue
+		}
+		ttl := rec.TTL
+		if ttl == 0 {
+			ttl = mdnsTTL
+		}
+		reply.Answers = append(reply.Answers, dnsmsg.ResourceRecord{
+			Name: domain, Type: recordTypeValue(rec.Type), Class: qclass, TTL: ttl, RData: rdata,
+		})
+	}
+
+	response, err := reply.Pack()
+	if err != nil {
+		return nil
+	}
+	return response
+}
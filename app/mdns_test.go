@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NurlanTanatar/localdns/dnsmsg"
+)
+
+func newLoopbackUDP(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func packMDNSQuery(name string, qtype, qclass uint16) []byte {
+	msg := dnsmsg.Message{
+		Questions: []dnsmsg.Question{{Name: name, Type: qtype, Class: qclass}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		panic(err)
+	}
+	return packed
+}
+
+func expectResponse(t *testing.T, conn *net.UDPConn) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 512)
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("expected a response, got error: %v", err)
+	}
+	return buf[:n]
+}
+
+func expectNoResponse(t *testing.T, conn *net.UDPConn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	buf := make([]byte, 512)
+	if _, _, err := conn.ReadFromUDP(buf); err == nil {
+		t.Fatal("expected no response, got one")
+	}
+}
+
+func testMDNSZones() *ZoneStore {
+	return newTestZoneStore(ZoneFile{
+		"host.local": {{Type: "A", TTL: 120, Value: "127.0.0.1"}},
+	})
+}
+
+func TestHandleMDNSQueryUnicastRequested(t *testing.T) {
+	server := newLoopbackUDP(t)
+	group := newLoopbackUDP(t)
+	source := newLoopbackUDP(t)
+
+	query := packMDNSQuery("host.local", dnsmsg.TypeA, dnsmsg.ClassIN|mdnsClassUnicastBit)
+
+	handleMDNSQuery(server, group.LocalAddr().(*net.UDPAddr), source.LocalAddr().(*net.UDPAddr), query, testMDNSZones())
+
+	expectResponse(t, source)
+	expectNoResponse(t, group)
+}
+
+func TestHandleMDNSQueryMulticastDefault(t *testing.T) {
+	server := newLoopbackUDP(t)
+	group := newLoopbackUDP(t)
+	source := newLoopbackUDP(t)
+
+	query := packMDNSQuery("host.local", dnsmsg.TypeA, dnsmsg.ClassIN)
+
+	handleMDNSQuery(server, group.LocalAddr().(*net.UDPAddr), source.LocalAddr().(*net.UDPAddr), query, testMDNSZones())
+
+	expectResponse(t, group)
+	expectNoResponse(t, source)
+}
+
+func TestHandleMDNSQueryIgnoresNonLocalNames(t *testing.T) {
+	server := newLoopbackUDP(t)
+	group := newLoopbackUDP(t)
+	source := newLoopbackUDP(t)
+
+	query := packMDNSQuery("host.example.com", dnsmsg.TypeA, dnsmsg.ClassIN)
+
+	handleMDNSQuery(server, group.LocalAddr().(*net.UDPAddr), source.LocalAddr().(*net.UDPAddr), query, testMDNSZones())
+
+	expectNoResponse(t, group)
+	expectNoResponse(t, source)
+}
+
+func TestHandleMDNSQueryIgnoresWrongClass(t *testing.T) {
+	server := newLoopbackUDP(t)
+	group := newLoopbackUDP(t)
+	source := newLoopbackUDP(t)
+
+	query := packMDNSQuery("host.local", dnsmsg.TypeA, 3) // neither IN nor IN|unicast-bit
+
+	handleMDNSQuery(server, group.LocalAddr().(*net.UDPAddr), source.LocalAddr().(*net.UDPAddr), query, testMDNSZones())
+
+	expectNoResponse(t, group)
+	expectNoResponse(t, source)
+}
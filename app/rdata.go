@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/NurlanTanatar/localdns/dnsmsg"
+)
+
+// encodeName writes name in DNS wire format (length-prefixed labels
+// terminated by a zero-length label). It does not perform any name
+// compression.
+func encodeName(name string) []byte {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return []byte{0x00}
+	}
+
+	var out []byte
+	for _, label := range strings.Split(name, ".") {
+		out = append(out, byte(len(label)))
+		out = append(out, []byte(label)...)
+	}
+	out = append(out, 0x00)
+	return out
+}
+
+// buildRDATA renders the RDATA for a single record, keyed by the record's
+// declared type.
+func buildRDATA(rec RecordConfig) ([]byte, error) {
+	switch strings.ToUpper(rec.Type) {
+	case "A":
+		ip := net.ParseIP(rec.Value).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid A value %q", rec.Value)
+		}
+		return ip, nil
+
+	case "AAAA":
+		ip := net.ParseIP(rec.Value).To16()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid AAAA value %q", rec.Value)
+		}
+		return ip, nil
+
+	case "CNAME", "PTR", "NS":
+		return encodeName(rec.Value), nil
+
+	case "MX":
+		rdata := make([]byte, 2)
+		binary.BigEndian.PutUint16(rdata, rec.Priority)
+		return append(rdata, encodeName(rec.Target)...), nil
+
+	case "TXT":
+		var rdata []byte
+		text := []byte(rec.Value)
+		for len(text) > 0 {
+			chunk := text
+			if len(chunk) > 255 {
+				chunk = chunk[:255]
+			}
+			rdata = append(rdata, byte(len(chunk)))
+			rdata = append(rdata, chunk...)
+			text = text[len(chunk):]
+		}
+		if rdata == nil {
+			rdata = []byte{0x00}
+		}
+		return rdata, nil
+
+	case "SRV":
+		rdata := make([]byte, 6)
+		binary.BigEndian.PutUint16(rdata[0:2], rec.Priority)
+		binary.BigEndian.PutUint16(rdata[2:4], rec.Weight)
+		binary.BigEndian.PutUint16(rdata[4:6], rec.Port)
+		return append(rdata, encodeName(rec.Target)...), nil
+
+	case "SOA":
+		rdata := encodeName(rec.MName)
+		rdata = append(rdata, encodeName(rec.RName)...)
+		tail := make([]byte, 20)
+		binary.BigEndian.PutUint32(tail[0:4], rec.Serial)
+		binary.BigEndian.PutUint32(tail[4:8], rec.Refresh)
+		binary.BigEndian.PutUint32(tail[8:12], rec.Retry)
+		binary.BigEndian.PutUint32(tail[12:16], rec.Expire)
+		binary.BigEndian.PutUint32(tail[16:20], rec.Minimum)
+		return append(rdata, tail...), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported record type %q", rec.Type)
+	}
+}
+
+// recordTypeValue maps a zone file type string back to its QTYPE/TYPE value.
+func recordTypeValue(typ string) uint16 {
+	switch strings.ToUpper(typ) {
+	case "A":
+		return dnsmsg.TypeA
+	case "NS":
+		return dnsmsg.TypeNS
+	case "CNAME":
+		return dnsmsg.TypeCNAME
+	case "SOA":
+		return dnsmsg.TypeSOA
+	case "PTR":
+		return dnsmsg.TypePTR
+	case "MX":
+		return dnsmsg.TypeMX
+	case "TXT":
+		return dnsmsg.TypeTXT
+	case "AAAA":
+		return dnsmsg.TypeAAAA
+	case "SRV":
+		return dnsmsg.TypeSRV
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// tcpIdleTimeout bounds how long a TCP connection may sit without sending a
+// complete query before it's closed. It's a var, not a const, so tests can
+// shrink it instead of waiting out the real timeout.
+var tcpIdleTimeout = 30 * time.Second
+
+// RunTCPServer listens for DNS-over-TCP connections on addr and answers
+// them through the same query pipeline as the UDP listener. Per RFC 1035
+// §4.2.2, each message is prefixed with its length as a 2-byte big-endian
+// integer. It only returns if listening or accepting fails; call it in its
+// own goroutine.
+func RunTCPServer(addr string, zones *ZoneStore, forwarder *Forwarder) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Println("tcp: failed to listen:", err)
+		return
+	}
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			fmt.Println("tcp: accept error:", err)
+			return
+		}
+		go handleTCPConn(conn, zones, forwarder)
+	}
+}
+
+// handleTCPConn serves queries from a single connection until it's closed
+// or goes idle for longer than tcpIdleTimeout.
+func handleTCPConn(conn net.Conn, zones *ZoneStore, forwarder *Forwarder) {
+	defer conn.Close()
+
+	var clientIP net.IP
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		clientIP = tcpAddr.IP
+	}
+
+	lengthBuf := make([]byte, 2)
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+
+		if _, err := io.ReadFull(conn, lengthBuf); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint16(lengthBuf)
+
+		query := make([]byte, length)
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+
+		response := createResponse(query, zones, forwarder, clientIP, true)
+
+		out := make([]byte, 2+len(response))
+		binary.BigEndian.PutUint16(out[0:2], uint16(len(response)))
+		copy(out[2:], response)
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
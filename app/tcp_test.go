@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NurlanTanatar/localdns/dnsmsg"
+)
+
+func writeLengthPrefixed(t *testing.T, conn net.Conn, payload []byte) {
+	t.Helper()
+	lengthBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lengthBuf, uint16(len(payload)))
+	if _, err := conn.Write(lengthBuf); err != nil {
+		t.Fatalf("write length prefix: %v", err)
+	}
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+}
+
+func readLengthPrefixed(t *testing.T, conn net.Conn) []byte {
+	t.Helper()
+	lengthBuf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, lengthBuf); err != nil {
+		t.Fatalf("read length prefix: %v", err)
+	}
+	length := binary.BigEndian.Uint16(lengthBuf)
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	return payload
+}
+
+func TestHandleTCPConnMultipleQueries(t *testing.T) {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handleTCPConn(server, testMDNSZones(), nil)
+		close(done)
+	}()
+
+	for i := 0; i < 2; i++ {
+		query := packTestQuery(uint16(i+1), "host.local", dnsmsg.TypeA)
+		writeLengthPrefixed(t, client, query)
+
+		resp := readLengthPrefixed(t, client)
+		var msg dnsmsg.Message
+		if err := msg.Unpack(resp); err != nil {
+			t.Fatalf("Unpack response %d: %v", i, err)
+		}
+		if msg.Header.ID != uint16(i+1) {
+			t.Errorf("response %d: got ID %d, want %d", i, msg.Header.ID, i+1)
+		}
+	}
+
+	client.Close()
+	<-done
+}
+
+// TestHandleTCPConnLyingLengthPrefix drives a length prefix that promises
+// more bytes than the client actually sends before closing the connection;
+// handleTCPConn must give up on the short read and return rather than
+// hanging or panicking.
+func TestHandleTCPConnLyingLengthPrefix(t *testing.T) {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handleTCPConn(server, testMDNSZones(), nil)
+		close(done)
+	}()
+
+	lengthBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lengthBuf, 100) // claims 100 bytes are coming
+	if _, err := client.Write(lengthBuf); err != nil {
+		t.Fatalf("write length prefix: %v", err)
+	}
+	if _, err := client.Write([]byte{0x00, 0x01}); err != nil { // only 2 of the promised 100
+		t.Fatalf("write short payload: %v", err)
+	}
+	client.Close()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handleTCPConn did not return after a lying length prefix and a closed connection")
+	}
+}
+
+func TestHandleTCPConnIdleTimeout(t *testing.T) {
+	orig := tcpIdleTimeout
+	tcpIdleTimeout = 50 * time.Millisecond
+	defer func() { tcpIdleTimeout = orig }()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		handleTCPConn(server, testMDNSZones(), nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handleTCPConn did not close an idle connection within tcpIdleTimeout")
+	}
+}
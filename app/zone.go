@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/NurlanTanatar/localdns/dnsmsg"
+)
+
+// RecordConfig is the on-disk representation of a single resource record
+// inside a zone file. Only the fields relevant to Type are expected to be
+// populated; the rest are ignored.
+type RecordConfig struct {
+	Type     string `json:"type"`
+	TTL      uint32 `json:"ttl"`
+	Value    string `json:"value"`    // A, AAAA, CNAME, PTR, NS target; TXT text
+	Priority uint16 `json:"priority"` // MX preference, SRV priority
+	Weight   uint16 `json:"weight"`   // SRV weight
+	Port     uint16 `json:"port"`     // SRV port
+	Target   string `json:"target"`   // MX exchange, SRV target
+	// SOA fields
+	MName   string `json:"mname"`
+	RName   string `json:"rname"`
+	Serial  uint32 `json:"serial"`
+	Refresh uint32 `json:"refresh"`
+	Retry   uint32 `json:"retry"`
+	Expire  uint32 `json:"expire"`
+	Minimum uint32 `json:"minimum"`
+}
+
+// ZoneFile is the top-level shape of a zone config: a map of owner name to
+// the list of records defined at that name. Names are matched
+// case-insensitively and a leading "*." label matches any name below that
+// suffix, at any depth, that has no exact entry of its own; the wildcard
+// with the longest matching suffix wins.
+type ZoneFile map[string][]RecordConfig
+
+// ZoneStore is the in-memory, query-ready form of a loaded ZoneFile.
+type ZoneStore struct {
+	records map[string][]RecordConfig
+}
+
+// LoadZoneFile reads and parses a JSON zone config from path.
+func LoadZoneFile(path string) (*ZoneStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading zone file: %w", err)
+	}
+
+	var zf ZoneFile
+	if err := json.Unmarshal(data, &zf); err != nil {
+		return nil, fmt.Errorf("parsing zone file %s: %w", path, err)
+	}
+
+	store := &ZoneStore{records: make(map[string][]RecordConfig, len(zf))}
+	for name, recs := range zf {
+		store.records[normalizeName(name)] = recs
+	}
+	return store, nil
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Lookup returns the records of qtype defined for name, along with whether
+// the owner name exists in the zone at all (regardless of qtype). Wildcard
+// entries ("*.example.com") are used only when there is no exact match for
+// name.
+func (z *ZoneStore) Lookup(name string, qtype uint16) (recs []RecordConfig, nameExists bool) {
+	if z == nil {
+		return nil, false
+	}
+
+	key := normalizeName(name)
+	all, ok := z.records[key]
+	if !ok {
+		if wildcard, wok := z.wildcardMatch(key); wok {
+			all, ok = wildcard, true
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+
+	wantType := recordTypeName(qtype)
+	for _, r := range all {
+		if strings.EqualFold(r.Type, wantType) {
+			recs = append(recs, r)
+		}
+	}
+
+	// Per standard DNS behavior, a name with a CNAME and nothing else answers
+	// any QTYPE with that CNAME (the resolver is expected to follow it).
+	if len(recs) == 0 && qtype != dnsmsg.TypeCNAME {
+		for _, r := range all {
+			if strings.EqualFold(r.Type, "CNAME") {
+				recs = append(recs, r)
+			}
+		}
+	}
+	return recs, true
+}
+
+// wildcardMatch looks for a "*.suffix" entry covering key, trying
+// progressively shorter suffixes the way RFC 1034 §4.3.3 describes.
+func (z *ZoneStore) wildcardMatch(key string) ([]RecordConfig, bool) {
+	labels := strings.Split(key, ".")
+	for i := 1; i < len(labels); i++ {
+		candidate := "*." + strings.Join(labels[i:], ".")
+		if recs, ok := z.records[candidate]; ok {
+			return recs, true
+		}
+	}
+	return nil, false
+}
+
+// recordTypeName maps a QTYPE value to the string used in zone files.
+func recordTypeName(qtype uint16) string {
+	switch qtype {
+	case dnsmsg.TypeA:
+		return "A"
+	case dnsmsg.TypeNS:
+		return "NS"
+	case dnsmsg.TypeCNAME:
+		return "CNAME"
+	case dnsmsg.TypeSOA:
+		return "SOA"
+	case dnsmsg.TypePTR:
+		return "PTR"
+	case dnsmsg.TypeMX:
+		return "MX"
+	case dnsmsg.TypeTXT:
+		return "TXT"
+	case dnsmsg.TypeAAAA:
+		return "AAAA"
+	case dnsmsg.TypeSRV:
+		return "SRV"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/NurlanTanatar/localdns/dnsmsg"
+)
+
+func newTestZoneStore(zf ZoneFile) *ZoneStore {
+	store := &ZoneStore{records: make(map[string][]RecordConfig, len(zf))}
+	for name, recs := range zf {
+		store.records[normalizeName(name)] = recs
+	}
+	return store
+}
+
+func TestLookupExactMatch(t *testing.T) {
+	zones := newTestZoneStore(ZoneFile{
+		"example.com": {{Type: "A", TTL: 300, Value: "127.0.0.1"}},
+	})
+
+	recs, nameExists := zones.Lookup("example.com", dnsmsg.TypeA)
+	if !nameExists {
+		t.Fatal("expected name to exist")
+	}
+	if len(recs) != 1 || recs[0].Value != "127.0.0.1" {
+		t.Errorf("unexpected records: %+v", recs)
+	}
+}
+
+func TestLookupNXDomain(t *testing.T) {
+	zones := newTestZoneStore(ZoneFile{
+		"example.com": {{Type: "A", TTL: 300, Value: "127.0.0.1"}},
+	})
+
+	_, nameExists := zones.Lookup("nope.example.com", dnsmsg.TypeA)
+	if nameExists {
+		t.Error("expected name not to exist")
+	}
+}
+
+func TestLookupNoDataForType(t *testing.T) {
+	zones := newTestZoneStore(ZoneFile{
+		"example.com": {{Type: "AAAA", TTL: 300, Value: "::1"}},
+	})
+
+	recs, nameExists := zones.Lookup("example.com", dnsmsg.TypeA)
+	if !nameExists {
+		t.Fatal("expected name to exist")
+	}
+	if len(recs) != 0 {
+		t.Errorf("expected no A records, got %+v", recs)
+	}
+}
+
+func TestLookupWildcard(t *testing.T) {
+	zones := newTestZoneStore(ZoneFile{
+		"*.example.com": {{Type: "A", TTL: 60, Value: "127.0.0.1"}},
+	})
+
+	recs, nameExists := zones.Lookup("anything.example.com", dnsmsg.TypeA)
+	if !nameExists {
+		t.Fatal("expected wildcard match to exist")
+	}
+	if len(recs) != 1 || recs[0].Value != "127.0.0.1" {
+		t.Errorf("unexpected records: %+v", recs)
+	}
+}
+
+func TestLookupExactBeatsWildcard(t *testing.T) {
+	zones := newTestZoneStore(ZoneFile{
+		"*.example.com":    {{Type: "A", TTL: 60, Value: "127.0.0.1"}},
+		"mail.example.com": {{Type: "A", TTL: 60, Value: "127.0.0.2"}},
+	})
+
+	recs, _ := zones.Lookup("mail.example.com", dnsmsg.TypeA)
+	if len(recs) != 1 || recs[0].Value != "127.0.0.2" {
+		t.Errorf("exact match should take precedence over wildcard, got %+v", recs)
+	}
+}
+
+func TestLookupCNAMEFallback(t *testing.T) {
+	zones := newTestZoneStore(ZoneFile{
+		"www.example.com": {{Type: "CNAME", TTL: 300, Value: "example.com"}},
+	})
+
+	recs, nameExists := zones.Lookup("www.example.com", dnsmsg.TypeA)
+	if !nameExists {
+		t.Fatal("expected name to exist")
+	}
+	if len(recs) != 1 || recs[0].Type != "CNAME" {
+		t.Errorf("expected CNAME fallback, got %+v", recs)
+	}
+
+	// A direct CNAME query shouldn't fall back into itself a second time.
+	recs, _ = zones.Lookup("www.example.com", dnsmsg.TypeCNAME)
+	if len(recs) != 1 || recs[0].Type != "CNAME" {
+		t.Errorf("expected the CNAME record itself, got %+v", recs)
+	}
+}
@@ -0,0 +1,374 @@
+// Package dnsmsg provides a structured representation of a DNS message
+// (RFC 1035 §4) with Pack/Unpack to and from wire format, replacing
+// hand-indexed byte slicing at the call sites that used to do this inline.
+package dnsmsg
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// Opcode values (RFC 1035 §4.1.1).
+const (
+	OpcodeQuery  = 0
+	OpcodeIQuery = 1
+	OpcodeStatus = 2
+)
+
+// RCODE values (RFC 1035 §4.1.1).
+const (
+	RcodeNoError  = 0
+	RcodeFormErr  = 1
+	RcodeServFail = 2
+	RcodeNXDomain = 3
+	RcodeNotImp   = 4
+	RcodeRefused  = 5
+)
+
+// Resource record TYPE/QTYPE values used by this package's callers.
+const (
+	TypeA     = 1
+	TypeNS    = 2
+	TypeCNAME = 5
+	TypeSOA   = 6
+	TypePTR   = 12
+	TypeMX    = 15
+	TypeTXT   = 16
+	TypeAAAA  = 28
+	TypeSRV   = 33
+	TypeOPT   = 41
+)
+
+// ClassIN is the Internet resource record class (RFC 1035 §3.2.4).
+const ClassIN = 1
+
+// maxCompressionHops bounds how many compression pointers Unpack will
+// follow for a single name, so a pointer loop can't hang the parser.
+const maxCompressionHops = 10
+
+// maxCompressionOffset is the largest byte offset a 14-bit compression
+// pointer can address.
+const maxCompressionOffset = 0x3FFF
+
+// Header is the fixed 12-byte DNS message header, with its packed Flags
+// word broken out into named fields.
+type Header struct {
+	ID      uint16
+	QR      bool
+	Opcode  uint8
+	AA      bool
+	TC      bool
+	RD      bool
+	RA      bool
+	Z       uint8
+	RCODE   uint8
+	QDCount uint16
+	ANCount uint16
+	NSCount uint16
+	ARCount uint16
+}
+
+// Question is a single entry in a message's question section.
+type Question struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// ResourceRecord is a single answer/authority/additional record. RData
+// carries the already-encoded RDATA bytes; this package doesn't interpret
+// them per record type.
+type ResourceRecord struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	RData []byte
+}
+
+// Message is a full DNS message.
+type Message struct {
+	Header      Header
+	Questions   []Question
+	Answers     []ResourceRecord
+	Authorities []ResourceRecord
+	Additionals []ResourceRecord
+}
+
+// SetReply turns m into a reply to req: it copies req's ID and RD bit, sets
+// QR=1, Opcode=Query, RCODE=NoError, and copies req's first question (if
+// any) so the reply echoes what was asked.
+func (m *Message) SetReply(req *Message) {
+	m.Header = Header{
+		ID:     req.Header.ID,
+		QR:     true,
+		Opcode: OpcodeQuery,
+		RD:     req.Header.RD,
+		RCODE:  RcodeNoError,
+	}
+	if len(req.Questions) > 0 {
+		m.Questions = []Question{req.Questions[0]}
+	}
+}
+
+// SetRcode sets msg's RCODE.
+func SetRcode(msg *Message, rcode int) {
+	msg.Header.RCODE = uint8(rcode)
+}
+
+// Pack encodes m to wire format, compressing names with back-references to
+// any identical suffix already written earlier in the message.
+func (m *Message) Pack() ([]byte, error) {
+	buf := make([]byte, 12)
+
+	flags := uint16(0)
+	if m.Header.QR {
+		flags |= 1 << 15
+	}
+	flags |= uint16(m.Header.Opcode&0x0F) << 11
+	if m.Header.AA {
+		flags |= 1 << 10
+	}
+	if m.Header.TC {
+		flags |= 1 << 9
+	}
+	if m.Header.RD {
+		flags |= 1 << 8
+	}
+	if m.Header.RA {
+		flags |= 1 << 7
+	}
+	flags |= uint16(m.Header.Z&0x07) << 4
+	flags |= uint16(m.Header.RCODE & 0x0F)
+
+	binary.BigEndian.PutUint16(buf[0:2], m.Header.ID)
+	binary.BigEndian.PutUint16(buf[2:4], flags)
+	binary.BigEndian.PutUint16(buf[4:6], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(buf[6:8], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(buf[8:10], uint16(len(m.Authorities)))
+	binary.BigEndian.PutUint16(buf[10:12], uint16(len(m.Additionals)))
+
+	compress := make(map[string]int)
+
+	for _, q := range m.Questions {
+		buf = appendName(buf, q.Name, compress)
+		buf = appendUint16(buf, q.Type)
+		buf = appendUint16(buf, q.Class)
+	}
+	for _, sections := range [][]ResourceRecord{m.Answers, m.Authorities, m.Additionals} {
+		for _, rr := range sections {
+			var err error
+			buf, err = appendRR(buf, rr, compress)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buf, nil
+}
+
+func appendRR(buf []byte, rr ResourceRecord, compress map[string]int) ([]byte, error) {
+	buf = appendName(buf, rr.Name, compress)
+	buf = appendUint16(buf, rr.Type)
+	buf = appendUint16(buf, rr.Class)
+	buf = appendUint32(buf, rr.TTL)
+	if len(rr.RData) > 0xFFFF {
+		return nil, errors.New("dnsmsg: RDATA too long")
+	}
+	buf = appendUint16(buf, uint16(len(rr.RData)))
+	return append(buf, rr.RData...), nil
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendName writes name in wire format, emitting a compression pointer to
+// the longest previously-seen suffix instead of spelling it out again.
+func appendName(buf []byte, name string, compress map[string]int) []byte {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return append(buf, 0x00)
+	}
+
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		suffix := strings.Join(labels[i:], ".")
+		if offset, ok := compress[suffix]; ok {
+			ptr := uint16(0xC000) | uint16(offset)
+			return append(buf, byte(ptr>>8), byte(ptr))
+		}
+		if len(buf) <= maxCompressionOffset {
+			compress[suffix] = len(buf)
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, []byte(label)...)
+	}
+	return append(buf, 0x00)
+}
+
+// Unpack parses data into m.
+func (m *Message) Unpack(data []byte) error {
+	if len(data) < 12 {
+		return errors.New("dnsmsg: packet shorter than header")
+	}
+
+	flags := binary.BigEndian.Uint16(data[2:4])
+	m.Header = Header{
+		ID:      binary.BigEndian.Uint16(data[0:2]),
+		QR:      flags&(1<<15) != 0,
+		Opcode:  uint8((flags >> 11) & 0x0F),
+		AA:      flags&(1<<10) != 0,
+		TC:      flags&(1<<9) != 0,
+		RD:      flags&(1<<8) != 0,
+		RA:      flags&(1<<7) != 0,
+		Z:       uint8((flags >> 4) & 0x07),
+		RCODE:   uint8(flags & 0x0F),
+		QDCount: binary.BigEndian.Uint16(data[4:6]),
+		ANCount: binary.BigEndian.Uint16(data[6:8]),
+		NSCount: binary.BigEndian.Uint16(data[8:10]),
+		ARCount: binary.BigEndian.Uint16(data[10:12]),
+	}
+
+	pos := 12
+
+	m.Questions = nil
+	for i := 0; i < int(m.Header.QDCount); i++ {
+		name, n, err := parseName(data, pos)
+		if err != nil {
+			return err
+		}
+		pos += n
+		if pos+4 > len(data) {
+			return errors.New("dnsmsg: truncated question section")
+		}
+		m.Questions = append(m.Questions, Question{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(data[pos : pos+2]),
+			Class: binary.BigEndian.Uint16(data[pos+2 : pos+4]),
+		})
+		pos += 4
+	}
+
+	for _, dst := range []struct {
+		count int
+		recs  *[]ResourceRecord
+	}{
+		{int(m.Header.ANCount), &m.Answers},
+		{int(m.Header.NSCount), &m.Authorities},
+		{int(m.Header.ARCount), &m.Additionals},
+	} {
+		*dst.recs = nil
+		for i := 0; i < dst.count; i++ {
+			rr, n, err := parseRR(data, pos)
+			if err != nil {
+				return err
+			}
+			pos += n
+			*dst.recs = append(*dst.recs, rr)
+		}
+	}
+
+	return nil
+}
+
+func parseRR(data []byte, offset int) (ResourceRecord, int, error) {
+	name, n, err := parseName(data, offset)
+	if err != nil {
+		return ResourceRecord{}, 0, err
+	}
+	pos := offset + n
+
+	if pos+10 > len(data) {
+		return ResourceRecord{}, 0, errors.New("dnsmsg: truncated resource record")
+	}
+	rr := ResourceRecord{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(data[pos : pos+2]),
+		Class: binary.BigEndian.Uint16(data[pos+2 : pos+4]),
+		TTL:   binary.BigEndian.Uint32(data[pos+4 : pos+8]),
+	}
+	rdlength := int(binary.BigEndian.Uint16(data[pos+8 : pos+10]))
+	pos += 10
+
+	if pos+rdlength > len(data) {
+		return ResourceRecord{}, 0, errors.New("dnsmsg: RDATA runs past end of packet")
+	}
+	rr.RData = append([]byte{}, data[pos:pos+rdlength]...)
+	pos += rdlength
+
+	return rr, pos - offset, nil
+}
+
+// parseName reads a domain name starting at offset, following RFC 1035
+// §4.1.4 compression pointers. It returns the number of bytes consumed from
+// the original position: a followed pointer only ever costs 2 bytes there,
+// regardless of how far it jumps or how many more pointers it chains
+// through.
+func parseName(packet []byte, offset int) (string, int, error) {
+	if offset >= len(packet) {
+		return "", 0, errors.New("dnsmsg: offset beyond packet length")
+	}
+
+	pos := offset
+	var name string
+	bytesRead := 0
+	jumped := false
+	hops := 0
+
+	for {
+		if pos >= len(packet) {
+			return "", 0, errors.New("dnsmsg: incomplete domain name")
+		}
+		length := int(packet[pos])
+
+		if length&0xC0 == 0xC0 {
+			if pos+1 >= len(packet) {
+				return "", 0, errors.New("dnsmsg: truncated compression pointer")
+			}
+			hops++
+			if hops > maxCompressionHops {
+				return "", 0, errors.New("dnsmsg: too many compression pointer hops")
+			}
+			if !jumped {
+				bytesRead += 2
+			}
+			pos = (length&0x3F)<<8 | int(packet[pos+1])
+			jumped = true
+			continue
+		}
+
+		if length == 0 {
+			if !jumped {
+				bytesRead++
+			}
+			break
+		}
+
+		pos++
+		if !jumped {
+			bytesRead++
+		}
+
+		if pos+length > len(packet) {
+			return "", 0, errors.New("dnsmsg: incomplete label")
+		}
+		if name != "" {
+			name += "."
+		}
+		name += string(packet[pos : pos+length])
+
+		pos += length
+		if !jumped {
+			bytesRead += length
+		}
+	}
+
+	return name, bytesRead, nil
+}
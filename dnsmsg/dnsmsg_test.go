@@ -0,0 +1,87 @@
+package dnsmsg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPackUnpackRoundTrip(t *testing.T) {
+	msg := Message{
+		Header: Header{ID: 0x1234, QR: true, AA: true, RD: true, RCODE: RcodeNoError},
+		Questions: []Question{
+			{Name: "example.com", Type: TypeA, Class: ClassIN},
+		},
+		Answers: []ResourceRecord{
+			{Name: "example.com", Type: TypeA, Class: ClassIN, TTL: 300, RData: []byte{127, 0, 0, 1}},
+			{Name: "www.example.com", Type: TypeCNAME, Class: ClassIN, TTL: 300, RData: []byte{3, 'w', 'w', 'w'}},
+		},
+	}
+
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+
+	var got Message
+	if err := got.Unpack(packed); err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+
+	// Pack() derives the count fields from slice lengths, so the round-trip
+	// comparison needs them filled in on the original too.
+	msg.Header.QDCount = uint16(len(msg.Questions))
+	msg.Header.ANCount = uint16(len(msg.Answers))
+	if !reflect.DeepEqual(msg.Header, got.Header) {
+		t.Errorf("Header mismatch: want %+v, got %+v", msg.Header, got.Header)
+	}
+	if !reflect.DeepEqual(msg.Questions, got.Questions) {
+		t.Errorf("Questions mismatch: want %+v, got %+v", msg.Questions, got.Questions)
+	}
+	if !reflect.DeepEqual(msg.Answers, got.Answers) {
+		t.Errorf("Answers mismatch: want %+v, got %+v", msg.Answers, got.Answers)
+	}
+}
+
+func TestPackCompressesRepeatedNames(t *testing.T) {
+	msg := Message{
+		Header:    Header{ID: 1, QR: true},
+		Questions: []Question{{Name: "example.com", Type: TypeA, Class: ClassIN}},
+		Answers: []ResourceRecord{
+			{Name: "example.com", Type: TypeA, Class: ClassIN, TTL: 60, RData: []byte{1, 2, 3, 4}},
+		},
+	}
+
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+
+	// The answer's owner name repeats the question's; it should be encoded
+	// as a pointer back to offset 12 (where the question name starts)
+	// rather than the full name again.
+	wantPointer := []byte{0xC0, 0x0C}
+	questionNameLen := 1 + len("example") + 1 + len("com") + 1 // labels + root
+	answerNameStart := 12 + questionNameLen + 4                // + QTYPE/QCLASS
+	gotBytes := packed[answerNameStart : answerNameStart+2]
+	if !reflect.DeepEqual(gotBytes, wantPointer) {
+		t.Errorf("answer name not compressed to a pointer: got %x, want %x", gotBytes, wantPointer)
+	}
+
+	var got Message
+	if err := got.Unpack(packed); err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+	if got.Answers[0].Name != "example.com" {
+		t.Errorf("compressed name didn't round-trip: got %q", got.Answers[0].Name)
+	}
+}
+
+func TestParseNameRejectsPointerLoop(t *testing.T) {
+	// A compression pointer at offset 12 that points right back at itself.
+	data := make([]byte, 12)
+	data = append(data, 0xC0, 12)
+
+	if _, _, err := parseName(data, 12); err == nil {
+		t.Fatal("expected an error for a compression pointer loop, got nil")
+	}
+}